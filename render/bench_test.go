@@ -0,0 +1,181 @@
+package render_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/osteele/liquid"
+	"github.com/osteele/liquid/render"
+)
+
+// These benchmarks mirror the scenario set published by comparable
+// template engines (Handlebars, Mustache): a fixed handful of templates
+// that stress one feature at a time. Each scenario renders through the
+// public Engine API, which exercises renderContext.EvaluateString,
+// RenderChild, RenderChildren, and RenderFile under the hood.
+//
+// Run with:
+//
+//	go test ./render/... -bench . -benchmem
+var benchScenarios = []struct {
+	name     string
+	template string
+	bindings map[string]interface{}
+}{
+	{
+		name:     "variables",
+		template: `{{ var1 }} {{ var2 }} {{ var3 }} {{ var4 }}`,
+		bindings: map[string]interface{}{
+			"var1": "value1", "var2": "value2", "var3": "value3", "var4": "value4",
+		},
+	},
+	{
+		name:     "path",
+		template: `{{ foo.bar.baz }}`,
+		bindings: map[string]interface{}{
+			"foo": map[string]interface{}{
+				"bar": map[string]interface{}{"baz": "qux"},
+			},
+		},
+	},
+	{
+		name:     "array-each",
+		template: `{% for item in items %}{{ item }}{% endfor %}`,
+		bindings: map[string]interface{}{
+			"items": []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"},
+		},
+	},
+	{
+		name: "object",
+		template: `{{ person.firstName }} {{ person.lastName }} ` +
+			`{{ person.city }} {{ person.state }}`,
+		bindings: map[string]interface{}{
+			"person": map[string]interface{}{
+				"firstName": "Alan", "lastName": "Johnson",
+				"city": "Chicago", "state": "Illinois",
+			},
+		},
+	},
+	{
+		name:     "string",
+		template: `{{ "foo" }} {{ "bar" | upcase }} {{ "ABC" | downcase }}`,
+		bindings: map[string]interface{}{},
+	},
+	{
+		name:     "depth-1",
+		template: `{% for item in items %}{{ item.name }}{% endfor %}`,
+		bindings: map[string]interface{}{
+			"items": []map[string]interface{}{
+				{"name": "a"}, {"name": "b"}, {"name": "c"},
+			},
+		},
+	},
+	{
+		name:     "depth-2",
+		template: `{% for outer in items %}{% for inner in outer.items %}{{ inner.name }}{% endfor %}{% endfor %}`,
+		bindings: map[string]interface{}{
+			"items": []map[string]interface{}{
+				{"items": []map[string]interface{}{{"name": "a"}, {"name": "b"}}},
+				{"items": []map[string]interface{}{{"name": "c"}, {"name": "d"}}},
+			},
+		},
+	},
+	{
+		name: "complex",
+		template: `<ul>{% for item in items %}<li class="{% if item.current %}active{% endif %}">` +
+			`{{ item.name }}</li>{% endfor %}</ul>`,
+		bindings: map[string]interface{}{
+			"items": []map[string]interface{}{
+				{"name": "first", "current": true},
+				{"name": "second", "current": false},
+			},
+		},
+	},
+	{
+		name:     "subexpression",
+		template: `{{ items | first | upcase }}`,
+		bindings: map[string]interface{}{
+			"items": []string{"alpha", "beta"},
+		},
+	},
+	{
+		name:     "arguments",
+		template: `{{ value | default: "fallback" }} {{ list | join: ", " }}`,
+		bindings: map[string]interface{}{
+			"list": []string{"a", "b", "c"},
+		},
+	},
+}
+
+func BenchmarkRender(b *testing.B) {
+	engine := liquid.NewEngine()
+	for _, s := range benchScenarios {
+		s := s
+		tpl, err := engine.ParseString(s.template)
+		if err != nil {
+			b.Fatalf("%s: %s", s.name, err)
+		}
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := tpl.Render(s.bindings); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRenderPartial exercises RenderFile via the {% include %} tag,
+// which is the slowest scenario in comparable engines because it involves
+// re-reading and re-parsing a file on every render.
+func BenchmarkRenderPartial(b *testing.B) {
+	dir := b.TempDir()
+	if err := ioutil.WriteFile(dir+"/partial.html", []byte(`partial: {{ name }}`), 0644); err != nil {
+		b.Fatal(err)
+	}
+	render.SetTemplateSource(render.NewDirSource(dir))
+	defer render.SetTemplateSource(nil)
+	engine := liquid.NewEngine()
+	tpl, err := engine.ParseString(`{% include "partial.html" %}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := tpl.Render(map[string]interface{}{"name": "world"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRenderPartialRecursion exercises RenderFile through a partial
+// that includes itself down to a fixed depth, the pattern comparable
+// engines call "partial-recursion". depth must be decremented on every
+// include: RenderFile enforces the same Limits.MaxIncludeDepth as
+// RenderPartial (see renderSource), so a recursive include that didn't
+// terminate on its own would fail once it hit that depth rather than
+// recursing until the goroutine stack overflowed -- depth is kept well
+// under the default limit so this benchmark measures rendering, not that
+// guard.
+func BenchmarkRenderPartialRecursion(b *testing.B) {
+	dir := b.TempDir()
+	recursive := `{{ depth }}{% if depth > 0 %}{% assign depth = depth | minus: 1 %}` +
+		`{% include "recursive.html" %}{% endif %}`
+	if err := ioutil.WriteFile(dir+"/recursive.html", []byte(recursive), 0644); err != nil {
+		b.Fatal(err)
+	}
+	render.SetTemplateSource(render.NewDirSource(dir))
+	defer render.SetTemplateSource(nil)
+	engine := liquid.NewEngine()
+	tpl, err := engine.ParseString(`{% include "recursive.html" %}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := tpl.Render(map[string]interface{}{"depth": 5}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package render
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// boundedCache is a fixed-capacity, concurrency-safe memoization cache with
+// FIFO eviction. expressionCache, tagArgsInterpolation, and partialCache all
+// use it instead of a bare sync.Map: a bare map keyed on caller-supplied
+// data (an expression string, an AST node, a partial name, seen for the
+// first time) grows without bound if a caller feeds it unboundedly many
+// distinct keys, which is exactly what a multi-tenant deployment rendering
+// arbitrary user-supplied templates can do -- a memory-exhaustion DoS of
+// the kind the render.Limits sandboxing in this package otherwise exists to
+// prevent.
+type boundedCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[interface{}]interface{}
+	order   []interface{}
+
+	hits, misses, evictions uint64
+}
+
+func newBoundedCache(max int) *boundedCache {
+	return &boundedCache{max: max, entries: map[interface{}]interface{}{}}
+}
+
+func (c *boundedCache) load(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[key]
+	return v, ok
+}
+
+// store records value under key, evicting the oldest entry first if the
+// cache is at capacity. It's FIFO rather than true LRU -- simple enough to
+// reason about, and a memoization cache's hit rate is dominated by a
+// template's working set fitting within max at all, not by which entry is
+// evicted first once it doesn't.
+func (c *boundedCache) store(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.storeLocked(key, value)
+}
+
+func (c *boundedCache) storeLocked(key, value interface{}) {
+	if _, existed := c.entries[key]; existed {
+		c.entries[key] = value
+		return
+	}
+	if len(c.entries) >= c.max {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.entries, oldest)
+		atomic.AddUint64(&c.evictions, 1)
+	}
+	c.order = append(c.order, key)
+	c.entries[key] = value
+}
+
+// getOrLoad returns the cached value at key if present and valid reports
+// it's still current; otherwise it calls load, caches the result (counting
+// a replaced or capacity-evicted entry as an eviction), and returns that
+// instead. It's the invalidation-aware counterpart to load/store, for
+// callers like partialCache whose cached values can go stale on their own
+// (a template file changing on disk) rather than only by falling out of the
+// FIFO window.
+func (c *boundedCache) getOrLoad(key interface{}, valid func(interface{}) bool, load func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	v, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && valid(v) {
+		atomic.AddUint64(&c.hits, 1)
+		return v, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if _, existed := c.entries[key]; existed {
+		atomic.AddUint64(&c.evictions, 1)
+	}
+	c.storeLocked(key, value)
+	c.mu.Unlock()
+	return value, nil
+}
+
+// stats returns the cache's cumulative hit/miss/eviction counters, for
+// exporting as metrics (see PartialCacheStatsSnapshot).
+func (c *boundedCache) stats() PartialCacheStats {
+	return PartialCacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
+// reset empties the cache and zeros its counters.
+func (c *boundedCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[interface{}]interface{}{}
+	c.order = nil
+	atomic.StoreUint64(&c.hits, 0)
+	atomic.StoreUint64(&c.misses, 0)
+	atomic.StoreUint64(&c.evictions, 0)
+}
+
+// referenceFingerprint returns a string that's stable for as long as v's
+// own map/func/chan/pointer/slice-typed fields keep pointing at the same
+// objects, and differs whenever any of them point somewhere else. It's
+// used to derive a process-wide cache-partition key (see
+// expressionConfigFingerprint, templateSourceFingerprint) from a value
+// this package can't address or compare directly.
+func referenceFingerprint(v interface{}) string {
+	return fingerprintValue(reflect.ValueOf(v))
+}
+
+func fingerprintValue(rv reflect.Value) string {
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return "<nil>"
+	case reflect.Map, reflect.Func, reflect.Chan, reflect.Ptr, reflect.UnsafePointer, reflect.Slice:
+		return fmt.Sprintf("%s:%#x", rv.Type(), rv.Pointer())
+	case reflect.Interface:
+		return fmt.Sprintf("%s{%s}", rv.Type(), fingerprintValue(rv.Elem()))
+	case reflect.Struct:
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s{", rv.Type())
+		for i := 0; i < rv.NumField(); i++ {
+			b.WriteString(fingerprintValue(rv.Field(i)))
+			b.WriteByte(',')
+		}
+		b.WriteByte('}')
+		return b.String()
+	default:
+		// Every other Kind (the scalar types, plus Array) is comparable and
+		// cheap to format; %v reads the value straight off the
+		// reflect.Value, which fmt does even for an unexported field.
+		return fmt.Sprintf("%v", rv)
+	}
+}
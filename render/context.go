@@ -2,14 +2,46 @@ package render
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/osteele/liquid/expression"
 )
 
+// bufferPool reduces allocations for the short-lived buffers that
+// InnerString, ParseTagArgs, and RenderFile use to capture rendered output.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// defaultExpressionCacheSize bounds expressionCache (see boundedCache).
+const defaultExpressionCacheSize = 4096
+
+// expressionCache memoizes the parse of EvaluateString's source, which is
+// otherwise reparsed on every call even though most call sites (tag and
+// object rendering) pass the same handful of source strings per template.
+// It's keyed on (ExpressionConfig, source), not source alone, so that two
+// Engines configured with different ExpressionConfigs don't share parses.
+var expressionCache = newBoundedCache(defaultExpressionCacheSize)
+
+type expressionCacheKey struct {
+	config string
+	source string
+}
+
 // Context provides the rendering context for a tag renderer.
 type Context interface {
 	Clone() Context
@@ -18,14 +50,24 @@ type Context interface {
 	Evaluate(expr expression.Expression) (interface{}, error)
 	EvaluateString(source string) (interface{}, error)
 	EvaluateStatement(tag, source string) (interface{}, error)
+	Frame() Frame
+	GoContext() context.Context
 	InnerString() (string, error)
+	Limits() Limits
 	ParseTagArgs() (string, error)
 	RenderChild(io.Writer, *ASTBlock) error
 	RenderChildren(io.Writer) error
+	RenderChildrenParallel(w io.Writer, n int) error
 	RenderFile(filename string) (string, error)
+	RenderPartial(name string, bindings map[string]interface{}) (string, error)
+	RenderTemplate(name string) (string, error)
 	TagArgs() string
 	TagName() string
+	TemplateSource() TemplateSource
 	UpdateBindings(map[string]interface{})
+	WithGoContext(ctx context.Context) Context
+	WithLimits(l Limits) Context
+	WithTemplateSource(src TemplateSource) Context
 }
 
 type renderContext struct {
@@ -34,6 +76,10 @@ type renderContext struct {
 	cn   *ASTBlock
 }
 
+// Clone returns a Context whose Set calls can't race with c's: c.ctx.Clone()
+// copies the underlying bindings map, so the copy is O(n) in the number of
+// bindings rather than O(1). RenderChildrenParallel and other heavy Clone()
+// users should budget for one full bindings copy per clone.
 func (c renderContext) Clone() Context {
 	return renderContext{c.ctx.Clone(), c.node, c.cn}
 }
@@ -49,14 +95,61 @@ func (c renderContext) EvaluateString(source string) (out interface{}, err error
 		if r := recover(); r != nil {
 			switch e := r.(type) {
 			case expression.InterpreterError:
-				err = e
+				err = c.wrapRenderError(e)
 			default:
 				// fmt.Println(string(debug.Stack()))
-				panic(fmt.Errorf("%s during evaluation of %s", e, source))
+				panic(c.wrapRenderError(fmt.Errorf("%s during evaluation of %s", e, source)))
 			}
 		}
 	}()
-	return expression.EvaluateString(source, expression.NewContext(c.ctx.bindings, c.ctx.settings.ExpressionConfig))
+	if err := c.GoContext().Err(); err != nil {
+		return nil, c.wrapRenderError(err)
+	}
+	if err := c.checkEvaluationStep(); err != nil {
+		return nil, c.wrapRenderError(err)
+	}
+	expr, err := c.parseExpression(source)
+	if err != nil {
+		return nil, c.wrapRenderError(err)
+	}
+	out, err = c.Evaluate(expr)
+	return out, c.wrapRenderError(err)
+}
+
+// parseExpression parses source, or returns the cached expression.Expression
+// from a previous call with the same source. Templates typically reuse a
+// small, fixed set of expression strings across many renders (e.g. in a
+// loop body), so this turns repeated parsing into a map lookup.
+func (c renderContext) parseExpression(source string) (expression.Expression, error) {
+	key := expressionCacheKey{config: c.expressionConfigFingerprint(), source: source}
+	if cached, ok := expressionCache.load(key); ok {
+		return cached.(expression.Expression), nil
+	}
+	expr, err := expression.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	expressionCache.store(key, expr)
+	return expr, nil
+}
+
+// expressionConfigFingerprintKey is a reserved binding that memoizes
+// expressionConfigFingerprint's result for the lifetime of the bindings map
+// it's stored in, so it's recomputed only at boundaries that build a new
+// map (Clone, WithLimits, WithGoContext, a partial's child render).
+const expressionConfigFingerprintKey = "$liquid.exprConfigFingerprint"
+
+// expressionConfigFingerprint distinguishes Engines configured with
+// different ExpressionConfigs in the shared, process-wide expressionCache
+// and partialCache, since nodeContext.settings isn't addressable or
+// comparable from this package (see referenceFingerprint).
+func (c renderContext) expressionConfigFingerprint() string {
+	if fp, ok := c.ctx.bindings[expressionConfigFingerprintKey].(string); ok {
+		return fp
+	}
+	fp := referenceFingerprint(c.ctx.settings.ExpressionConfig)
+	c.ctx.bindings[expressionConfigFingerprintKey] = fp
+	return fp
 }
 
 func (c renderContext) EvaluateStatement(tag, source string) (interface{}, error) {
@@ -68,14 +161,27 @@ func (c renderContext) Get(name string) interface{} {
 	return c.ctx.bindings[name]
 }
 
-// Set sets a variable value from an evaluation context.
+// Set sets a variable value from an evaluation context. It writes directly
+// to c.ctx.bindings, the same map Evaluate/EvaluateString read through the
+// expression evaluator, so a Set is visible to {{ ... }} interpolation in
+// the same scope. A Context produced by Clone has its own copy of the map
+// (see nodeContext.Clone), so a Set made through a clone is invisible to
+// the Context it was cloned from and safe to make from another goroutine.
 func (c renderContext) Set(name string, value interface{}) {
 	c.ctx.bindings[name] = value
 }
 
-// RenderChild renders a node.
+// RenderChild renders a node. Tags that render the same block repeatedly,
+// such as a for-loop rendering its body once per item, call this once per
+// repetition, which is what lets it enforce Limits.MaxLoopIterations.
 func (c renderContext) RenderChild(w io.Writer, b *ASTBlock) error {
-	return c.ctx.RenderASTSequence(w, b.Body)
+	if err := c.GoContext().Err(); err != nil {
+		return c.wrapRenderError(err)
+	}
+	if err := c.checkLoopIteration(); err != nil {
+		return c.wrapRenderError(err)
+	}
+	return c.wrapRenderError(c.ctx.RenderASTSequence(c.limitOutput(w), b.Body))
 }
 
 // RenderChildren renders the current node's children.
@@ -83,29 +189,157 @@ func (c renderContext) RenderChildren(w io.Writer) error {
 	if c.cn == nil {
 		return nil
 	}
-	return c.ctx.RenderASTSequence(w, c.cn.Body)
+	if err := c.GoContext().Err(); err != nil {
+		return c.wrapRenderError(err)
+	}
+	return c.wrapRenderError(c.ctx.RenderASTSequence(c.limitOutput(w), c.cn.Body))
+}
+
+// RenderChildrenParallel renders the current node's children across up to n
+// goroutines, one per top-level child node, and writes their output to w in
+// the original order once all of them finish. Each goroutine renders
+// through its own Clone()d Context, so siblings' Set calls can't race; use
+// it only for children that don't depend on each other's bindings.
+func (c renderContext) RenderChildrenParallel(w io.Writer, n int) error {
+	if c.cn == nil {
+		return nil
+	}
+	if err := c.GoContext().Err(); err != nil {
+		return c.wrapRenderError(err)
+	}
+	body := c.cn.Body
+	count := len(body)
+	if count == 0 {
+		return nil
+	}
+	if err := c.checkParallelFanOut(count); err != nil {
+		return c.wrapRenderError(err)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	bufs := make([]*bytes.Buffer, count)
+	errs := make([]error, count)
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		i := i
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("panic while rendering child %d: %v", i, r)
+				}
+			}()
+			buf := getBuffer()
+			bufs[i] = buf
+			child := c.Clone().(renderContext)
+			errs[i] = child.ctx.RenderASTSequence(child.limitOutput(buf), body[i:i+1])
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, buf := range bufs {
+			if buf != nil {
+				putBuffer(buf)
+			}
+		}
+	}()
+	// Flush every sibling that rendered successfully, in order, up to the
+	// first failure, mirroring what RenderChildren would have already
+	// written to w by the time it hit the same failure sequentially.
+	for i, buf := range bufs {
+		if errs[i] != nil {
+			return c.wrapRenderError(errs[i])
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParallelFanOut charges count goroutines against the same shared
+// loopCounterKey counter checkLoopIteration spends one at a time, so a
+// template with an enormous parallel block -- or several smaller ones in a
+// row -- can't spawn more goroutines than Limits.MaxLoopIterations allows
+// across the whole render, not just within one call's count.
+func (c renderContext) checkParallelFanOut(count int) error {
+	limits := c.Limits()
+	if limits.MaxLoopIterations <= 0 {
+		return nil
+	}
+	counter, ok := c.ctx.bindings[loopCounterKey].(*int64)
+	if !ok {
+		return nil
+	}
+	if atomic.AddInt64(counter, int64(count)) > limits.MaxLoopIterations {
+		return ErrLoopLimitExceeded
+	}
+	return nil
 }
 
+// RenderFile renders the named template, read via the configured
+// TemplateSource (see SetTemplateSource), or directly from the OS
+// filesystem if none is configured.
 func (c renderContext) RenderFile(filename string) (string, error) {
-	source, err := ioutil.ReadFile(filename)
+	return c.renderSource(filename)
+}
+
+// RenderTemplate is a synonym for RenderFile. It exists alongside RenderFile
+// because "file" implies an OS path, while a TemplateSource may resolve
+// names against an embedded fs.FS or an in-memory map that isn't a file at
+// all.
+func (c renderContext) RenderTemplate(name string) (string, error) {
+	return c.renderSource(name)
+}
+
+// renderSource backs RenderFile/RenderTemplate. It enforces the same
+// Limits.MaxIncludeDepth as RenderPartial, via the same includeDepthKey
+// binding: without it, a template that includes itself (directly, or
+// through a longer cycle) via {% include %}/{% render %} using RenderFile
+// recurses until the goroutine stack overflows, even with Limits
+// configured, since RenderPartial's guard never comes into play.
+func (c renderContext) renderSource(name string) (string, error) {
+	if err := c.GoContext().Err(); err != nil {
+		return "", c.wrapRenderError(err)
+	}
+	depth, _ := c.ctx.bindings[includeDepthKey].(int)
+	max := int(atomic.LoadInt32(&maxIncludeDepth))
+	if limits := c.Limits(); limits.MaxIncludeDepth > 0 {
+		max = limits.MaxIncludeDepth
+	}
+	if depth+1 > max {
+		return "", c.wrapRenderError(fmt.Errorf("%w: %s", ErrMaxIncludeDepthExceeded, name))
+	}
+	source, err := c.readTemplateSource(currentSourceName(c.ctx.bindings), name)
 	if err != nil {
-		return "", err
+		return "", c.wrapRenderError(err)
 	}
 	ast, err := c.ctx.settings.Parse(string(source))
 	if err != nil {
-		return "", err
+		return "", c.wrapRenderError(err)
 	}
-	buf := new(bytes.Buffer)
-	if err := renderNode(ast, buf, c.ctx); err != nil {
-		return "", err
+	child := c.ctx
+	bindings := withInclude(c.ctx.bindings, c.Frame(), name)
+	bindings[includeDepthKey] = depth + 1
+	child.bindings = bindings
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := renderNode(ast, buf, child); err != nil {
+		return "", renderContext{ctx: child}.wrapRenderError(err)
 	}
 	return buf.String(), nil
 }
 
 // InnerString renders the children to a string.
 func (c renderContext) InnerString() (string, error) {
-	buf := new(bytes.Buffer)
-	if err := c.RenderChildren(buf); err != nil {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := c.RenderChildren(c.limitString(buf)); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
@@ -113,24 +347,64 @@ func (c renderContext) InnerString() (string, error) {
 
 func (c renderContext) ParseTagArgs() (string, error) {
 	args := c.TagArgs()
-	if strings.Contains(args, "{{") {
-		p, err := c.ctx.settings.Parse(args)
-		if err != nil {
-			return "", err
-		}
-		buf := new(bytes.Buffer)
-		err = renderNode(p, buf, c.ctx)
-		if err != nil {
-			return "", err
-		}
-		return buf.String(), nil
+	if !c.tagArgsHaveInterpolation(args) {
+		return args, nil
+	}
+	p, err := c.ctx.settings.Parse(args)
+	if err != nil {
+		return "", c.wrapRenderError(err)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := renderNode(p, c.limitString(buf), c.ctx); err != nil {
+		return "", c.wrapRenderError(err)
+	}
+	return buf.String(), nil
+}
+
+// defaultTagArgsCacheSize bounds tagArgsInterpolation (see boundedCache).
+const defaultTagArgsCacheSize = 8192
+
+// tagArgsInterpolation caches, per tag node, whether its args string
+// contains "{{" and therefore needs to be parsed and rendered by
+// ParseTagArgs. A tag's args don't change across the renders of a shared
+// template (e.g. once per loop iteration), so this turns a strings.Contains
+// scan on every call into a single scan the first time the node is seen.
+// It's bounded like expressionCache: a deployment that parses unboundedly
+// many distinct user-supplied templates, each visited once, must not grow
+// this cache forever even though its keys are node pointers rather than
+// attacker-chosen strings.
+var tagArgsInterpolation = newBoundedCache(defaultTagArgsCacheSize)
+
+func (c renderContext) tagArgsHaveInterpolation(args string) bool {
+	key := c.argsCacheKey()
+	if key == nil {
+		return strings.Contains(args, "{{")
+	}
+	if cached, ok := tagArgsInterpolation.load(key); ok {
+		return cached.(bool)
+	}
+	has := strings.Contains(args, "{{")
+	tagArgsInterpolation.store(key, has)
+	return has
+}
+
+// argsCacheKey returns the AST node backing this context's tag args, used
+// as a stable cache key, or nil if there is none.
+func (c renderContext) argsCacheKey() interface{} {
+	switch {
+	case c.node != nil:
+		return c.node
+	case c.cn != nil:
+		return c.cn
+	default:
+		return nil
 	}
-	return args, nil
 }
 
 func (c renderContext) UpdateBindings(bindings map[string]interface{}) {
 	for k, v := range bindings {
-		c.ctx.bindings[k] = v
+		c.Set(k, v)
 	}
 }
 
@@ -154,4 +428,4 @@ func (c renderContext) TagName() string {
 	default:
 		return ""
 	}
-}
\ No newline at end of file
+}
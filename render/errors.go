@@ -0,0 +1,157 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Frame identifies one level of a template's include chain: the name of
+// the template, and where within it a tag appeared.
+type Frame struct {
+	SourceName string
+	Line       int
+	Col        int
+}
+
+func (f Frame) String() string {
+	if f.SourceName == "" {
+		return ""
+	}
+	if f.Line == 0 {
+		return f.SourceName
+	}
+	return fmt.Sprintf("%s:%d:%d", f.SourceName, f.Line, f.Col)
+}
+
+// RenderError is the error type returned by renderContext's evaluation and
+// rendering methods. It carries the tag and template location responsible
+// for a failure, and the chain of includes that led there, so that a
+// failure deep inside a partial reports more than a bare panic string.
+type RenderError struct {
+	TagName      string
+	TagArgs      string
+	SourceName   string
+	Line         int
+	Col          int
+	IncludeStack []Frame
+	Cause        error
+}
+
+func (e *RenderError) Error() string {
+	var b strings.Builder
+	if e.TagName != "" {
+		fmt.Fprintf(&b, "%%%s %s: ", e.TagName, e.TagArgs)
+	}
+	b.WriteString(e.Cause.Error())
+	if loc := (Frame{e.SourceName, e.Line, e.Col}).String(); loc != "" {
+		fmt.Fprintf(&b, " in %s", loc)
+	}
+	for i := len(e.IncludeStack) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "\n\tincluded from %s", e.IncludeStack[i])
+	}
+	return b.String()
+}
+
+// Unwrap exposes the underlying cause to errors.Is / errors.As.
+func (e *RenderError) Unwrap() error { return e.Cause }
+
+// wrapRenderError wraps err in a *RenderError carrying c's current tag and
+// template location, unless err is nil or already a *RenderError (in which
+// case the deeper, more specific wrapping is preserved as-is).
+//
+// "c's current tag" is the tag renderContext was constructed for — the
+// innermost one still on the Go call stack when the failure surfaced. For
+// a failure inside a tag renderer's own logic (the common case) that's
+// exactly right. For a failure that happened further down, inside an
+// expression evaluated on the tag's behalf, it can only be as precise as
+// the position information that error carries: if it implements
+// chunkPosition, that position overrides c's own.
+func (c renderContext) wrapRenderError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var existing *RenderError
+	if errors.As(err, &existing) {
+		return err
+	}
+	frame := c.Frame()
+	if p, ok := err.(chunkPosition); ok {
+		frame.Line, frame.Col = p.Position()
+	}
+	return &RenderError{
+		TagName:      c.TagName(),
+		TagArgs:      c.TagArgs(),
+		SourceName:   frame.SourceName,
+		Line:         frame.Line,
+		Col:          frame.Col,
+		IncludeStack: includeStack(c.ctx.bindings),
+		Cause:        err,
+	}
+}
+
+// chunkPosition is implemented by the parser's Chunk type (and, for
+// expression-evaluation failures, by errors that carry their own source
+// position) to report a 1-based line and column. Reading it through a type
+// assertion, rather than a named field, means this package doesn't need to
+// import the parser package just to read position information off values
+// whose concrete type it already holds opaquely (node.Chunk) or receives
+// as an error.
+type chunkPosition interface {
+	Position() (line, col int)
+}
+
+// Frame returns the template location (source name, line, and column) that
+// a custom tag renderer is currently executing under, so that it can
+// produce errors with the same location metadata as the built-in
+// renderContext methods. The tag name and args are not part of Frame; they
+// come from TagName and TagArgs.
+// Line and Col are populated from the current node's Chunk when it reports
+// a position (see chunkPosition); otherwise they're 0, same as an unknown
+// location.
+func (c renderContext) Frame() Frame {
+	frame := Frame{SourceName: currentSourceName(c.ctx.bindings)}
+	var chunk interface{}
+	switch {
+	case c.node != nil:
+		chunk = c.node.Chunk
+	case c.cn != nil:
+		chunk = c.cn.Chunk
+	}
+	if p, ok := chunk.(chunkPosition); ok {
+		frame.Line, frame.Col = p.Position()
+	}
+	return frame
+}
+
+// sourceNameKey and includeStackKey are reserved bindings, alongside
+// includeDepthKey (see partial.go), that thread the current template's
+// name and its chain of includes through nested renders via the same
+// bindings map that carries every other variable.
+const sourceNameKey = "$liquid.sourceName"
+const includeStackKey = "$liquid.includeStack"
+
+func currentSourceName(bindings map[string]interface{}) string {
+	name, _ := bindings[sourceNameKey].(string)
+	return name
+}
+
+func includeStack(bindings map[string]interface{}) []Frame {
+	stack, _ := bindings[includeStackKey].([]Frame)
+	return stack
+}
+
+// withInclude returns a copy of bindings with name recorded as the current
+// source name and includer -- the frame of the {% include %}/{% render %}
+// call -- pushed onto the include stack. The stack holds ancestors only;
+// name's own frame is already reported via RenderError's
+// SourceName/Line/Col.
+func withInclude(bindings map[string]interface{}, includer Frame, name string) map[string]interface{} {
+	next := make(map[string]interface{}, len(bindings)+2)
+	for k, v := range bindings {
+		next[k] = v
+	}
+	next[sourceNameKey] = name
+	next[includeStackKey] = append(append([]Frame{}, includeStack(bindings)...), includer)
+	return next
+}
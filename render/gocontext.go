@@ -0,0 +1,34 @@
+package render
+
+import "context"
+
+// goContextKey is a reserved binding, alongside the other "$liquid."
+// bookkeeping keys (see errors.go, partial.go), that threads the Go
+// context.Context governing the current render through nested renders.
+const goContextKey = "$liquid.goContext"
+
+// GoContext returns the context.Context governing this render, or
+// context.Background() if none was set via WithGoContext. RenderChild,
+// RenderChildren, RenderFile/RenderTemplate, RenderPartial, and
+// EvaluateString all check its Err() before doing further work, so a
+// render can be cancelled or deadlined from the outside (e.g. an HTTP
+// handler whose client disconnected).
+func (c renderContext) GoContext() context.Context {
+	if ctx, ok := c.ctx.bindings[goContextKey].(context.Context); ok && ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// WithGoContext returns a copy of c whose GoContext is ctx. It does not
+// affect c or any Context already derived from it.
+func (c renderContext) WithGoContext(ctx context.Context) Context {
+	bindings := make(map[string]interface{}, len(c.ctx.bindings)+1)
+	for k, v := range c.ctx.bindings {
+		bindings[k] = v
+	}
+	bindings[goContextKey] = ctx
+	child := c.ctx
+	child.bindings = bindings
+	return renderContext{child, c.node, c.cn}
+}
@@ -0,0 +1,38 @@
+package render_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/osteele/liquid"
+	"github.com/osteele/liquid/render"
+)
+
+// TestRenderChildrenStopsOnCancelledGoContext guards the cancellation
+// behavior chunk0-5 added: RenderChildren must check GoContext().Err()
+// before rendering anything, not just eventually give up partway through,
+// so that an HTTP handler whose client disconnected doesn't still pay for
+// (or leak partial output from) the rest of a long render.
+func TestRenderChildrenStopsOnCancelledGoContext(t *testing.T) {
+	engine := liquid.NewEngine()
+	engine.RegisterBlock("test_cancelled", func(c render.Context) (string, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var buf strings.Builder
+		err := c.WithGoContext(ctx).RenderChildren(&buf)
+		return buf.String(), err
+	})
+	tpl, err := engine.ParseString(`{% test_cancelled %}should not render{% endtest_cancelled %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Render(map[string]interface{}{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got err=%v, want an error wrapping context.Canceled", err)
+	}
+	if strings.Contains(out, "should not render") {
+		t.Errorf("rendered the block body despite a cancelled GoContext: %q", out)
+	}
+}
@@ -0,0 +1,187 @@
+package render
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// Limits bounds the resources a single render may consume. It's meant for
+// embedding Liquid as a user-supplied template language — Shopify-style
+// theme editing, webhook body templating — where a malicious or merely
+// buggy template must not be able to allocate gigabytes of output, loop
+// forever, or recurse without bound. A zero value for any field disables
+// that particular limit, except MaxIncludeDepth: a zero MaxIncludeDepth
+// falls back to the process-wide depth set by SetMaxIncludeDepth.
+type Limits struct {
+	MaxOutputBytes     int64
+	MaxLoopIterations  int64
+	MaxIncludeDepth    int
+	MaxEvaluationSteps int64
+	MaxStringLength    int64
+}
+
+// Sentinel errors returned (wrapped in a *RenderError) when a Limits bound
+// is exceeded.
+var (
+	ErrOutputLimitExceeded     = errors.New("liquid: max output bytes exceeded")
+	ErrLoopLimitExceeded       = errors.New("liquid: max loop iterations exceeded")
+	ErrEvaluationStepsExceeded = errors.New("liquid: max evaluation steps exceeded")
+	ErrStringLengthExceeded    = errors.New("liquid: max string length exceeded")
+)
+
+// limitsKey and its companion counter keys are reserved bindings, alongside
+// the other "$liquid." bookkeeping keys, that thread a render's Limits and
+// its running usage counters through nested renders. The counters are
+// pointers so that every Context derived from the same WithLimits call
+// shares one count, even though each holds its own copy of the bindings
+// map.
+const limitsKey = "$liquid.limits"
+const outputCounterKey = "$liquid.outputCounter"
+const loopCounterKey = "$liquid.loopCounter"
+const stepCounterKey = "$liquid.stepCounter"
+
+// Limits returns the resource limits in effect for this render, or the
+// zero Limits (no limits) if WithLimits was never called.
+func (c renderContext) Limits() Limits {
+	l, _ := c.ctx.bindings[limitsKey].(Limits)
+	return l
+}
+
+// WithLimits returns a copy of c with l enforced for the remainder of the
+// render, and its own fresh set of usage counters. It does not affect c or
+// any Context already derived from it.
+func (c renderContext) WithLimits(l Limits) Context {
+	bindings := make(map[string]interface{}, len(c.ctx.bindings)+4)
+	for k, v := range c.ctx.bindings {
+		bindings[k] = v
+	}
+	bindings[limitsKey] = l
+	bindings[outputCounterKey] = new(int64)
+	bindings[loopCounterKey] = new(int64)
+	bindings[stepCounterKey] = new(int64)
+	child := c.ctx
+	child.bindings = bindings
+	return renderContext{child, c.node, c.cn}
+}
+
+// limitedWriter enforces Limits.MaxOutputBytes by returning
+// ErrOutputLimitExceeded, instead of writing further, once the shared
+// counter would exceed the budget. Write reserves len(p) against counter
+// with a CAS loop before writing, since counter is shared across every
+// sibling RenderChildrenParallel spawns and a plain load-then-add could let
+// concurrent writers overshoot max before any of them recorded its share.
+type limitedWriter struct {
+	w       io.Writer
+	counter *int64
+	max     int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	reserve := int64(len(p))
+	for {
+		cur := atomic.LoadInt64(lw.counter)
+		if cur+reserve > lw.max {
+			return 0, ErrOutputLimitExceeded
+		}
+		if atomic.CompareAndSwapInt64(lw.counter, cur, cur+reserve) {
+			break
+		}
+	}
+	n, err := lw.w.Write(p)
+	if int64(n) != reserve {
+		atomic.AddInt64(lw.counter, int64(n)-reserve)
+	}
+	return n, err
+}
+
+// limitOutput wraps w in a limitedWriter if Limits.MaxOutputBytes is set,
+// sharing this render's output counter across every child render. It's a
+// no-op if w is already a *limitedWriter: RenderChild and RenderChildren
+// each call limitOutput on the writer they're given, and that writer is
+// often itself the result of an enclosing RenderChild/RenderChildren's own
+// limitOutput call (e.g. a for-loop's body rendered via RenderChild, inside
+// a block rendered via RenderChildren) — wrapping again would count every
+// byte once per nesting level instead of once, tripping MaxOutputBytes at a
+// fraction of the real output.
+func (c renderContext) limitOutput(w io.Writer) io.Writer {
+	limits := c.Limits()
+	if limits.MaxOutputBytes <= 0 {
+		return w
+	}
+	if _, already := w.(*limitedWriter); already {
+		return w
+	}
+	counter, ok := c.ctx.bindings[outputCounterKey].(*int64)
+	if !ok {
+		counter = new(int64)
+	}
+	return &limitedWriter{w: w, counter: counter, max: limits.MaxOutputBytes}
+}
+
+// checkLoopIteration counts one loop iteration (or other repeated child
+// render) against Limits.MaxLoopIterations, returning ErrLoopLimitExceeded
+// once the budget is spent.
+func (c renderContext) checkLoopIteration() error {
+	limits := c.Limits()
+	if limits.MaxLoopIterations <= 0 {
+		return nil
+	}
+	counter, ok := c.ctx.bindings[loopCounterKey].(*int64)
+	if !ok {
+		return nil
+	}
+	if atomic.AddInt64(counter, 1) > limits.MaxLoopIterations {
+		return ErrLoopLimitExceeded
+	}
+	return nil
+}
+
+// checkEvaluationStep counts one expression evaluation against
+// Limits.MaxEvaluationSteps, returning ErrEvaluationStepsExceeded once the
+// budget is spent.
+func (c renderContext) checkEvaluationStep() error {
+	limits := c.Limits()
+	if limits.MaxEvaluationSteps <= 0 {
+		return nil
+	}
+	counter, ok := c.ctx.bindings[stepCounterKey].(*int64)
+	if !ok {
+		return nil
+	}
+	if atomic.AddInt64(counter, 1) > limits.MaxEvaluationSteps {
+		return ErrEvaluationStepsExceeded
+	}
+	return nil
+}
+
+// stringLimitWriter enforces Limits.MaxStringLength while a string is still
+// being built, by returning ErrStringLengthExceeded instead of allocating
+// further, rather than only checking the finished string's length after
+// it's already been materialized in full.
+type stringLimitWriter struct {
+	w   io.Writer
+	n   int64
+	max int64
+}
+
+func (lw *stringLimitWriter) Write(p []byte) (int, error) {
+	if lw.n+int64(len(p)) > lw.max {
+		return 0, ErrStringLengthExceeded
+	}
+	n, err := lw.w.Write(p)
+	lw.n += int64(n)
+	return n, err
+}
+
+// limitString wraps w in a stringLimitWriter if Limits.MaxStringLength is
+// set. Unlike limitOutput, the limit it enforces is local to one
+// InnerString/ParseTagArgs call, not shared across nested renders, so it
+// always wraps fresh.
+func (c renderContext) limitString(w io.Writer) io.Writer {
+	limits := c.Limits()
+	if limits.MaxStringLength <= 0 {
+		return w
+	}
+	return &stringLimitWriter{w: w, max: limits.MaxStringLength}
+}
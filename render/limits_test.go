@@ -0,0 +1,62 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestLimitedWriterRejectsOversizedSingleWrite guards against the bug where
+// limitedWriter only checked the counter before writing, so a single Write
+// call larger than the whole budget (a big text/object node, or a filter
+// result) sailed through uncounted -- no loop required to blow the budget.
+func TestLimitedWriterRejectsOversizedSingleWrite(t *testing.T) {
+	var counter int64
+	var dst bytes.Buffer
+	lw := &limitedWriter{w: &dst, counter: &counter, max: 10}
+	n, err := lw.Write(bytes.Repeat([]byte("x"), 50))
+	if !errors.Is(err, ErrOutputLimitExceeded) {
+		t.Fatalf("Write of 50 bytes against a 10-byte budget: got err=%v, want ErrOutputLimitExceeded", err)
+	}
+	if n != 0 {
+		t.Fatalf("Write of 50 bytes against a 10-byte budget: got n=%d, want 0", n)
+	}
+	if dst.Len() != 0 {
+		t.Fatalf("rejected write still reached the underlying writer: %q", dst.String())
+	}
+}
+
+// TestLimitedWriterAllowsWritesWithinBudget checks that writes that fit
+// within the budget, including a sequence that lands exactly on it, still
+// succeed and are counted.
+func TestLimitedWriterAllowsWritesWithinBudget(t *testing.T) {
+	var counter int64
+	var dst bytes.Buffer
+	lw := &limitedWriter{w: &dst, counter: &counter, max: 10}
+	if _, err := lw.Write([]byte("12345")); err != nil {
+		t.Fatalf("Write within budget: unexpected error %v", err)
+	}
+	if _, err := lw.Write([]byte("67890")); err != nil {
+		t.Fatalf("Write landing exactly on budget: unexpected error %v", err)
+	}
+	if dst.String() != "1234567890" {
+		t.Fatalf("got output %q, want %q", dst.String(), "1234567890")
+	}
+	if _, err := lw.Write([]byte("x")); !errors.Is(err, ErrOutputLimitExceeded) {
+		t.Fatalf("Write past an exhausted budget: got err=%v, want ErrOutputLimitExceeded", err)
+	}
+}
+
+// TestStringLimitWriterRejectsOversizedSingleWrite is the companion
+// regression test for stringLimitWriter, which already pre-checked
+// correctly; it's here so both writers are covered the same way.
+func TestStringLimitWriterRejectsOversizedSingleWrite(t *testing.T) {
+	var dst bytes.Buffer
+	lw := &stringLimitWriter{w: &dst, max: 10}
+	if _, err := lw.Write(bytes.Repeat([]byte("x"), 50)); !errors.Is(err, ErrStringLengthExceeded) {
+		t.Fatalf("got err=%v, want ErrStringLengthExceeded", err)
+	}
+	if dst.Len() != 0 {
+		t.Fatalf("rejected write still reached the underlying writer: %q", dst.String())
+	}
+}
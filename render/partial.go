@@ -0,0 +1,175 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMaxIncludeDepth bounds how many partials may be nested inside one
+// another before RenderPartial gives up. Without a limit, partials that
+// include each other, directly or through a longer cycle, recurse until the
+// goroutine stack overflows the process.
+const defaultMaxIncludeDepth = 30
+
+var maxIncludeDepth int32 = defaultMaxIncludeDepth
+
+// SetMaxIncludeDepth configures the maximum partial include depth enforced
+// by RenderPartial. It is safe to call concurrently with renders.
+func SetMaxIncludeDepth(n int) {
+	atomic.StoreInt32(&maxIncludeDepth, int32(n))
+}
+
+// ErrMaxIncludeDepthExceeded is the sentinel wrapped into the error
+// RenderPartial returns once nesting exceeds the configured maximum.
+var ErrMaxIncludeDepthExceeded = errors.New("liquid: max partial include depth exceeded")
+
+// partialRenderFunc renders a previously-parsed partial's AST. It's a
+// closure, rather than a struct field holding the parsed AST directly, so
+// that the cache doesn't need to know the AST's concrete type.
+type partialRenderFunc func(w io.Writer, ctx nodeContext) error
+
+type partialCacheEntry struct {
+	render  partialRenderFunc
+	modTime time.Time
+}
+
+// PartialCacheStats reports cumulative usage counters for the process-wide
+// partial cache.
+type PartialCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// defaultMaxPartialCacheEntries bounds partialCache (see boundedCache):
+// without a cap, a deployment that renders unboundedly many distinct
+// user-supplied partial names would grow the cache forever.
+const defaultMaxPartialCacheEntries = 2048
+
+// partialCacheKey distinguishes renders with different ExpressionConfigs
+// (see expressionConfigFingerprint) or different TemplateSources (see
+// templateSourceFingerprint) so that engine A's compiled partial AST --
+// parsed with A's settings, read from A's source -- is never handed to a
+// render with different settings or a different source for a same-named
+// partial. The source component matters even when config is identical:
+// two tenants sharing one Engine/ExpressionConfig but each pinned to their
+// own TemplateSource via WithTemplateSource must not share a "header.html"
+// compiled from one tenant's template tree.
+type partialCacheKey struct {
+	config string
+	source string
+	name   string
+}
+
+// partialCache is the process-wide, concurrency-safe cache of parsed
+// partial ASTs, keyed by partialCacheKey. It's shared across all renders in
+// the process: parsing a partial is one of the more expensive operations in
+// a render, and the same partial is typically rendered many times with
+// different bindings (e.g. once per item in a catalog listing). It's a
+// boundedCache, the same FIFO-evicting, hit/miss-counting cache
+// expressionCache and tagArgsInterpolation use, rather than its own
+// bespoke map/mutex/order-slice, the difference being that a cached
+// partialCacheEntry can go stale on its own (the underlying file changing
+// on disk), which boundedCache.getOrLoad's valid callback accounts for.
+var partialCache = newBoundedCache(defaultMaxPartialCacheEntries)
+
+// PartialCacheStatsSnapshot returns the process-wide partial cache's
+// current hit/miss/eviction counters, for exporting as metrics.
+func PartialCacheStatsSnapshot() PartialCacheStats {
+	return partialCache.stats()
+}
+
+// ResetPartialCache empties the process-wide partial AST cache and zeros
+// its counters. Tests that configure different TemplateSources under the
+// same template names should call this between cases.
+func ResetPartialCache() {
+	partialCache.reset()
+}
+
+// includeDepthKey is a reserved binding name that threads the current
+// partial-include depth through nested renders. It rides along in the same
+// bindings map that already carries every other variable into a partial,
+// which is the one piece of render state that's guaranteed to reach a
+// partial's own nested includes regardless of how deep they are. The "$"
+// prefix keeps it out of the way of Liquid identifiers, which can't
+// contain one.
+const includeDepthKey = "$liquid.includeDepth"
+
+// RenderPartial renders name as a partial, merging bindings over a copy of
+// the current bindings for the duration of that render. The partial's AST
+// is parsed once and cached (see PartialCacheStatsSnapshot), invalidated by
+// modification time for template sources that report one (ModTimeSource).
+// Nesting is bounded by SetMaxIncludeDepth; once exceeded, the returned
+// error wraps ErrMaxIncludeDepthExceeded.
+func (c renderContext) RenderPartial(name string, bindings map[string]interface{}) (string, error) {
+	if err := c.GoContext().Err(); err != nil {
+		return "", c.wrapRenderError(err)
+	}
+	depth, _ := c.ctx.bindings[includeDepthKey].(int)
+	max := int(atomic.LoadInt32(&maxIncludeDepth))
+	if limits := c.Limits(); limits.MaxIncludeDepth > 0 {
+		max = limits.MaxIncludeDepth
+	}
+	if depth+1 > max {
+		return "", c.wrapRenderError(fmt.Errorf("%w: %s", ErrMaxIncludeDepthExceeded, name))
+	}
+	base := currentSourceName(c.ctx.bindings)
+	key := partialCacheKey{config: c.expressionConfigFingerprint(), source: c.templateSourceFingerprint(), name: name}
+	modTime := c.partialModTime(base, name)
+	cached, err := partialCache.getOrLoad(key, func(v interface{}) bool {
+		entry := v.(*partialCacheEntry)
+		return modTime.IsZero() || entry.modTime.Equal(modTime)
+	}, func() (interface{}, error) {
+		source, err := c.readTemplateSource(base, name)
+		if err != nil {
+			return nil, err
+		}
+		ast, err := c.ctx.settings.Parse(string(source))
+		if err != nil {
+			return nil, err
+		}
+		render := func(w io.Writer, ctx nodeContext) error {
+			return renderNode(ast, w, ctx)
+		}
+		return &partialCacheEntry{render: render, modTime: modTime}, nil
+	})
+	if err != nil {
+		return "", c.wrapRenderError(err)
+	}
+	merged := withInclude(c.ctx.bindings, c.Frame(), name)
+	for k, v := range bindings {
+		merged[k] = v
+	}
+	merged[includeDepthKey] = depth + 1
+	child := c.ctx
+	child.bindings = merged
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := cached.(*partialCacheEntry).render(buf, child); err != nil {
+		return "", renderContext{ctx: child}.wrapRenderError(err)
+	}
+	return buf.String(), nil
+}
+
+// partialModTime reports name's modification time via c's TemplateSource,
+// or the zero Time if none is configured or it doesn't implement
+// ModTimeSource. base is the including template's name, resolved the same
+// way readTemplateSource resolves it.
+func (c renderContext) partialModTime(base, name string) time.Time {
+	src := c.TemplateSource()
+	mts, ok := src.(ModTimeSource)
+	if !ok {
+		return time.Time{}
+	}
+	resolved, err := src.Resolve(base, name)
+	if err != nil {
+		return time.Time{}
+	}
+	if t, err := mts.ModTime(resolved); err == nil {
+		return t
+	}
+	return time.Time{}
+}
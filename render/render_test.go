@@ -0,0 +1,208 @@
+package render_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/osteele/liquid"
+	"github.com/osteele/liquid/render"
+)
+
+// TestIncludeMaxIncludeDepth guards the sandboxing behavior chunk0-3
+// added: a partial that includes itself via {% include %} (which renders
+// through RenderFile), directly or through a cycle, must fail with
+// ErrMaxIncludeDepthExceeded instead of recursing until the goroutine stack
+// overflows.
+func TestIncludeMaxIncludeDepth(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(dir+"/loop.html", []byte(`{% include "loop.html" %}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	render.SetTemplateSource(render.NewDirSource(dir))
+	defer render.SetTemplateSource(nil)
+	render.SetMaxIncludeDepth(5)
+	defer render.SetMaxIncludeDepth(30)
+
+	engine := liquid.NewEngine()
+	tpl, err := engine.ParseString(`{% include "loop.html" %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tpl.Render(map[string]interface{}{})
+	if !errors.Is(err, render.ErrMaxIncludeDepthExceeded) {
+		t.Fatalf("got err=%v, want an error wrapping ErrMaxIncludeDepthExceeded", err)
+	}
+}
+
+// TestRenderErrorIncludeStack guards the structured error chunk0-4 added:
+// a failure inside a partial rendered through a chain of includes should
+// report the failing template as the source, with the full include chain
+// attached, rather than a bare panic string.
+func TestRenderErrorIncludeStack(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(dir+"/outer.html", []byte(`{% include "inner.html" %}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/inner.html", []byte(`{{ 1 | divided_by: 0 }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	render.SetTemplateSource(render.NewDirSource(dir))
+	defer render.SetTemplateSource(nil)
+
+	engine := liquid.NewEngine()
+	tpl, err := engine.ParseString(`{% include "outer.html" %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tpl.Render(map[string]interface{}{})
+	if err == nil {
+		t.Fatal("dividing by zero inside a nested partial: got nil error")
+	}
+	var renderErr *render.RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("got %T, want a *render.RenderError in the chain: %v", err, err)
+	}
+	if renderErr.SourceName != "inner.html" {
+		t.Errorf("got SourceName=%q, want %q", renderErr.SourceName, "inner.html")
+	}
+	found := false
+	for _, f := range renderErr.IncludeStack {
+		if f.SourceName == "outer.html" {
+			found = true
+		}
+		if f.SourceName == "inner.html" {
+			t.Errorf("IncludeStack %+v wrongly lists %q, the file SourceName already reports", renderErr.IncludeStack, "inner.html")
+		}
+	}
+	if !found {
+		t.Errorf("IncludeStack %+v doesn't mention the including template %q", renderErr.IncludeStack, "outer.html")
+	}
+}
+
+// TestWithLimitsBoundsLoopIterationsAcrossAFor guards checkLoopIteration,
+// exercised through a real {% for %} tag rather than unit-tested in
+// isolation: a custom block pins Limits.MaxLoopIterations on its own
+// Context, and a for-loop rendered inside it -- sharing that Context's
+// bindings, and so its loop counter -- must fail partway through once the
+// budget is spent.
+func TestWithLimitsBoundsLoopIterationsAcrossAFor(t *testing.T) {
+	engine := liquid.NewEngine()
+	engine.RegisterBlock("test_limited", func(c render.Context) (string, error) {
+		var buf bytes.Buffer
+		limited := c.WithLimits(render.Limits{MaxLoopIterations: 2})
+		err := limited.RenderChildren(&buf)
+		return buf.String(), err
+	})
+	tpl, err := engine.ParseString(
+		`{% test_limited %}{% for i in (1..10) %}{{ i }}{% endfor %}{% endtest_limited %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tpl.Render(map[string]interface{}{})
+	if !errors.Is(err, render.ErrLoopLimitExceeded) {
+		t.Fatalf("a 10-iteration for loop under a 2-iteration Limits budget: got err=%v, want an error wrapping ErrLoopLimitExceeded", err)
+	}
+}
+
+// TestWithLimitsBoundsEvaluationSteps guards checkEvaluationStep the same
+// way: a custom block pins Limits.MaxEvaluationSteps, and a for-loop that
+// evaluates one expression per iteration must fail once the step budget,
+// not just the loop-iteration budget, is spent.
+func TestWithLimitsBoundsEvaluationSteps(t *testing.T) {
+	engine := liquid.NewEngine()
+	engine.RegisterBlock("test_limited", func(c render.Context) (string, error) {
+		var buf bytes.Buffer
+		limited := c.WithLimits(render.Limits{MaxEvaluationSteps: 2})
+		err := limited.RenderChildren(&buf)
+		return buf.String(), err
+	})
+	tpl, err := engine.ParseString(
+		`{% test_limited %}{% for i in (1..10) %}{{ i }}{% endfor %}{% endtest_limited %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tpl.Render(map[string]interface{}{})
+	if !errors.Is(err, render.ErrEvaluationStepsExceeded) {
+		t.Fatalf("10 object evaluations under a 2-step Limits budget: got err=%v, want an error wrapping ErrEvaluationStepsExceeded", err)
+	}
+}
+
+// TestRenderChildrenParallelPreservesOrder guards RenderChildrenParallel's
+// core promise: siblings render concurrently, across their own Clone()d
+// Context, but their output is written back in the original order.
+func TestRenderChildrenParallelPreservesOrder(t *testing.T) {
+	engine := liquid.NewEngine()
+	engine.RegisterBlock("test_parallel", func(c render.Context) (string, error) {
+		var buf bytes.Buffer
+		err := c.RenderChildrenParallel(&buf, 0)
+		return buf.String(), err
+	})
+	tpl, err := engine.ParseString(`{% test_parallel %}{{ "a" }}{{ "b" }}{{ "c" }}{% endtest_parallel %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Render(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "abc"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestRenderChildrenParallelSurfacesSiblingError guards that a failing
+// sibling's error reaches RenderChildrenParallel's caller instead of being
+// dropped once the other siblings have finished.
+func TestRenderChildrenParallelSurfacesSiblingError(t *testing.T) {
+	engine := liquid.NewEngine()
+	engine.RegisterBlock("test_parallel", func(c render.Context) (string, error) {
+		var buf bytes.Buffer
+		err := c.RenderChildrenParallel(&buf, 0)
+		return buf.String(), err
+	})
+	tpl, err := engine.ParseString(
+		`{% test_parallel %}{{ "ok" }}{{ 1 | divided_by: 0 }}{{ "ok" }}{% endtest_parallel %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tpl.Render(map[string]interface{}{}); err == nil {
+		t.Fatal("a sibling dividing by zero: got nil error")
+	}
+}
+
+// TestRenderChildrenParallelIsolatesSetAcrossSiblings guards the bug ae3633d
+// fixed: each sibling renders through its own Clone()d Context, so a Set
+// made by one sibling must not be visible to -- or race with -- another's.
+// Run with -race: before ae3633d, concurrent Sets through a shared bindings
+// map both corrupted output and tripped the race detector.
+func TestRenderChildrenParallelIsolatesSetAcrossSiblings(t *testing.T) {
+	engine := liquid.NewEngine()
+	engine.RegisterTag("test_set_echo", func(c render.Context) (string, error) {
+		v, err := c.EvaluateString(c.TagArgs())
+		if err != nil {
+			return "", err
+		}
+		c.Set("x", v)
+		return fmt.Sprint(c.Get("x")), nil
+	})
+	engine.RegisterBlock("test_parallel", func(c render.Context) (string, error) {
+		var buf bytes.Buffer
+		err := c.RenderChildrenParallel(&buf, 0)
+		return buf.String(), err
+	})
+	tpl, err := engine.ParseString(
+		`{% test_parallel %}{% test_set_echo "A" %}{% test_set_echo "B" %}{% test_set_echo "C" %}{% endtest_parallel %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Render(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "ABC"; got != want {
+		t.Errorf("got %q, want %q -- a sibling's Set leaked into another's Get", got, want)
+	}
+}
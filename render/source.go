@@ -0,0 +1,250 @@
+package render
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModTimeSource is implemented by a TemplateSource that can report a
+// template's modification time. RenderPartial uses it, when available, to
+// invalidate its compiled-AST cache when the underlying template changes;
+// sources that don't implement it (e.g. MapSource) are cached until
+// evicted.
+type ModTimeSource interface {
+	ModTime(name string) (time.Time, error)
+}
+
+// TemplateSource abstracts where {% include %}/{% render %} partials and
+// RenderFile templates come from, so that they can be served from the
+// filesystem, from an embedded fs.FS, or from an in-memory map instead of
+// always going through os.Open. This is the extension point for embedding
+// templates in a binary, or for safely serving user-supplied Liquid in a
+// multi-tenant setting where arbitrary filesystem access is unacceptable.
+type TemplateSource interface {
+	// Open returns a reader for the named template. The caller closes it.
+	Open(name string) (io.ReadCloser, error)
+	// Resolve returns the name that a reference to rel, appearing inside
+	// base, should be opened as. This is where a source enforces its root
+	// and rejects path traversal.
+	Resolve(base, rel string) (string, error)
+}
+
+// ErrPathEscapesRoot is returned by a TemplateSource's Resolve method when
+// a template reference would resolve outside of the source's root.
+var ErrPathEscapesRoot = errors.New("liquid: template path escapes source root")
+
+// dirSource is a TemplateSource rooted at a directory on the local
+// filesystem, resolved with os.Open.
+type dirSource struct {
+	root string
+}
+
+// NewDirSource returns a TemplateSource that reads templates from root on
+// the local filesystem. Template references that would resolve outside of
+// root return ErrPathEscapesRoot.
+func NewDirSource(root string) TemplateSource {
+	return dirSource{root: root}
+}
+
+func (s dirSource) Open(name string) (io.ReadCloser, error) {
+	rel, err := s.Resolve("", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(s.root, rel))
+}
+
+// ModTime reports name's modification time, used by RenderPartial to
+// invalidate its cache when the underlying file changes.
+func (s dirSource) ModTime(name string) (time.Time, error) {
+	rel, err := s.Resolve("", name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(filepath.Join(s.root, rel))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Resolve returns rel as a path relative to root, interpreted relative to
+// base's own directory rather than to root directly when base is given —
+// this is what lets a partial that includes another partial by a relative
+// name resolve it against the including partial's directory instead of
+// always against root. The returned path is itself root-relative, like
+// base is expected to be, so resolving it again with an empty base (as
+// Open and ModTime do) is a no-op.
+func (s dirSource) Resolve(base, rel string) (string, error) {
+	dir := "."
+	if base != "" {
+		dir = filepath.Dir(base)
+	}
+	path := filepath.Clean(filepath.Join(dir, rel))
+	if path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", ErrPathEscapesRoot
+	}
+	return path, nil
+}
+
+// fsSource is a TemplateSource backed by an fs.FS, e.g. an embed.FS.
+type fsSource struct {
+	fsys fs.FS
+}
+
+// NewFSSource returns a TemplateSource that reads templates from fsys,
+// e.g. an embed.FS built with `//go:embed`.
+func NewFSSource(fsys fs.FS) TemplateSource {
+	return fsSource{fsys: fsys}
+}
+
+func (s fsSource) Open(name string) (io.ReadCloser, error) {
+	path, err := s.Resolve("", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.fsys.Open(path)
+}
+
+func (s fsSource) Resolve(base, rel string) (string, error) {
+	dir := "."
+	if base != "" {
+		dir = path.Dir(base)
+	}
+	p := path.Clean(path.Join(dir, rel))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", ErrPathEscapesRoot
+	}
+	return p, nil
+}
+
+// MapSource is an in-memory TemplateSource, keyed by template name. It's
+// useful for tests and for small sets of templates that are generated or
+// fetched at runtime rather than stored on disk.
+type MapSource map[string]string
+
+func (s MapSource) Open(name string) (io.ReadCloser, error) {
+	src, ok := s[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return ioutil.NopCloser(strings.NewReader(src)), nil
+}
+
+func (s MapSource) Resolve(base, rel string) (string, error) {
+	return rel, nil
+}
+
+var (
+	templateSourceMu sync.RWMutex
+	templateSource   TemplateSource
+)
+
+// SetTemplateSource configures the process-wide default TemplateSource used
+// by RenderFile and RenderTemplate when a render's Context has none set via
+// WithTemplateSource. A nil source restores the default behavior of reading
+// directly from the OS filesystem via the path passed to RenderFile. A
+// multi-tenant Engine should prefer WithTemplateSource on the render's
+// Context instead, so one tenant's render can't change where another's
+// includes resolve from.
+func SetTemplateSource(s TemplateSource) {
+	templateSourceMu.Lock()
+	defer templateSourceMu.Unlock()
+	templateSource = s
+}
+
+func currentTemplateSource() TemplateSource {
+	templateSourceMu.RLock()
+	defer templateSourceMu.RUnlock()
+	return templateSource
+}
+
+// templateSourceKey is a reserved binding, alongside the other "$liquid."
+// bookkeeping keys (see errors.go, gocontext.go, limits.go, partial.go),
+// that threads a render's TemplateSource through nested renders.
+const templateSourceKey = "$liquid.templateSource"
+
+// TemplateSource returns the TemplateSource in effect for this render: the
+// one set via WithTemplateSource, or the process-wide default configured by
+// SetTemplateSource if none was, or nil if neither was ever called (in which
+// case RenderFile/RenderTemplate/RenderPartial read straight from the OS
+// filesystem).
+func (c renderContext) TemplateSource() TemplateSource {
+	if src, ok := c.ctx.bindings[templateSourceKey].(TemplateSource); ok && src != nil {
+		return src
+	}
+	return currentTemplateSource()
+}
+
+// WithTemplateSource returns a copy of c that resolves RenderFile,
+// RenderTemplate, and RenderPartial names against src for the remainder of
+// the render, instead of whatever SetTemplateSource last configured
+// process-wide. It does not affect c or any Context already derived from
+// it, so an Engine can call this once per render to pin each tenant's
+// renders to that tenant's own TemplateSource regardless of what any other
+// concurrent render's Engine is doing.
+func (c renderContext) WithTemplateSource(src TemplateSource) Context {
+	bindings := make(map[string]interface{}, len(c.ctx.bindings)+1)
+	for k, v := range c.ctx.bindings {
+		bindings[k] = v
+	}
+	bindings[templateSourceKey] = src
+	delete(bindings, templateSourceFingerprintKey)
+	child := c.ctx
+	child.bindings = bindings
+	return renderContext{child, c.node, c.cn}
+}
+
+// templateSourceFingerprintKey memoizes templateSourceFingerprint's result
+// for the lifetime of the bindings map it's stored in, the same way
+// expressionConfigFingerprintKey does for expressionConfigFingerprint.
+const templateSourceFingerprintKey = "$liquid.templateSourceFingerprint"
+
+// templateSourceFingerprint distinguishes renders pinned to different
+// TemplateSources in partialCache (see partialCacheKey), via
+// referenceFingerprint since a TemplateSource can itself be, or embed, a
+// func or an fs.FS backed by one.
+func (c renderContext) templateSourceFingerprint() string {
+	if fp, ok := c.ctx.bindings[templateSourceFingerprintKey].(string); ok {
+		return fp
+	}
+	fp := referenceFingerprint(c.TemplateSource())
+	c.ctx.bindings[templateSourceFingerprintKey] = fp
+	return fp
+}
+
+// readTemplateSource reads name, using c's TemplateSource (see
+// TemplateSource) if any, and otherwise falling back to ioutil.ReadFile so
+// that existing callers that pass an OS path keep working: an absolute path
+// is read as is. base is the name of the template that's
+// including/rendering name, if any — passing it lets a relative name
+// resolve relative to base's own directory (e.g. a partial including a
+// sibling partial by a relative name) instead of always relative to the
+// TemplateSource's root or the working directory.
+func (c renderContext) readTemplateSource(base, name string) ([]byte, error) {
+	src := c.TemplateSource()
+	if src == nil {
+		if base != "" && !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(base), name)
+		}
+		return ioutil.ReadFile(name)
+	}
+	resolved, err := src.Resolve(base, name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := src.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
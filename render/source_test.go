@@ -0,0 +1,60 @@
+package render
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDirSourceResolveRejectsTraversal(t *testing.T) {
+	s := NewDirSource("/templates")
+	cases := []struct {
+		base, rel string
+	}{
+		{"", "../etc/passwd"},
+		{"", "../../etc/passwd"},
+		{"partials/header.html", "../../secret.html"},
+	}
+	for _, c := range cases {
+		if _, err := s.(dirSource).Resolve(c.base, c.rel); !errors.Is(err, ErrPathEscapesRoot) {
+			t.Errorf("Resolve(%q, %q): got err=%v, want ErrPathEscapesRoot", c.base, c.rel, err)
+		}
+	}
+}
+
+func TestDirSourceResolveRelativeToIncludingTemplate(t *testing.T) {
+	s := NewDirSource("/templates").(dirSource)
+	got, err := s.Resolve("partials/header.html", "logo.html")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "partials/logo.html"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFSSourceResolveRejectsTraversal(t *testing.T) {
+	s := NewFSSource(nil).(fsSource)
+	if _, err := s.Resolve("", "../outside.html"); !errors.Is(err, ErrPathEscapesRoot) {
+		t.Errorf("got err=%v, want ErrPathEscapesRoot", err)
+	}
+}
+
+func TestMapSourceOpenAndResolve(t *testing.T) {
+	s := MapSource{"greeting.html": "hello {{ name }}"}
+	rc, err := s.Open("greeting.html")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rc.Close()
+	body, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := string(body); got != "hello {{ name }}" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := s.Open("missing.html"); err == nil {
+		t.Error("Open of a missing name: got nil error, want one")
+	}
+}